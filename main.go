@@ -1,31 +1,81 @@
 package main
 
 import (
+	"archive/tar"
 	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	mathrand "math/rand"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/joho/godotenv"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// Defaults applied when a Payload field is left unset.
+const (
+	defaultObjectSizeBytes = 1024
+	defaultConcurrency     = 50
+
+	// chunkSizeThresholdBytes is the object size above which writes default
+	// to resumable uploads instead of a single-request multipart upload.
+	chunkSizeThresholdBytes = 8 * 1024 * 1024
+	defaultChunkSizeBytes   = 16 * 1024 * 1024
+
+	// defaultListLimit caps how many objects handleListObjects returns when
+	// the caller doesn't supply ?limit=.
+	defaultListLimit = 1000
+)
+
+// Retry tuning for writeObjectWithRetry. Vars rather than consts so tests
+// can shrink the backoff instead of waiting on real timers.
+var (
+	maxWriteAttempts    = 5
+	initialRetryBackoff = 100 * time.Millisecond
+)
+
+const retryBackoffFactor = 2
+
+// Error categories surfaced in ResponsePayload.ErrorsByType.
+const (
+	errCategoryBucketNotFound     = "bucket_not_found"
+	errCategoryPermissionDenied   = "permission_denied"
+	errCategoryPreconditionFailed = "precondition_failed"
+	errCategoryServerError        = "server_error"
+	errCategoryUnknown            = "unknown"
 )
 
 // Payload represents the incoming JSON structure.
 type Payload struct {
-	Number int `json:"number"`
+	ObjectCount     int `json:"object_count"`
+	ObjectSizeBytes int `json:"object_size_bytes"`
+	Concurrency     int `json:"concurrency"`
+	ChunkSizeBytes  int `json:"chunk_size_bytes"`
 }
 
 // ResponsePayload represents the outgoing JSON structure.
 type ResponsePayload struct {
-	ObjectsWritten int      `json:"objects_written"`
-	TimeTaken      string   `json:"time_taken"`
-	Errors         []string `json:"errors,omitempty"`
+	ObjectsWritten    int            `json:"objects_written"`
+	TotalBytesWritten int64          `json:"total_bytes_written"`
+	Throughput        float64        `json:"throughput_mb_per_sec"`
+	TimeTaken         string         `json:"time_taken"`
+	Errors            []string       `json:"errors,omitempty"`
+	ErrorsByType      map[string]int `json:"errors_by_type,omitempty"`
 }
 
 // Global variables for the GCS client and bucket name.
@@ -34,6 +84,11 @@ var (
 	bucketName string
 )
 
+// newStorageClient constructs the GCS client used by main. It is a package
+// variable so tests can point handleRequest at a fake server instead of
+// real GCS.
+var newStorageClient = storage.NewClient
+
 func main() {
 
 	errDot := godotenv.Load()
@@ -46,7 +101,7 @@ func main() {
 	// Initialize GCS client and bucket name.
 	ctx := context.Background()
 	var err error
-	gcsClient, err = storage.NewClient(ctx)
+	gcsClient, err = newStorageClient(ctx)
 	if err != nil {
 		log.Fatalf("Failed to create GCS client: %v", err)
 	}
@@ -54,14 +109,18 @@ func main() {
 
 	// Replace with your actual bucket name.
 	// You can also use an environment variable: os.Getenv("GCS_BUCKET_NAME")
-	bucketName := os.Getenv("GCS_BUCKET_NAME")
+	bucketName = os.Getenv("GCS_BUCKET_NAME")
 	if bucketName == "" {
 		log.Fatal("GCS_BUCKET_NAME environment variable not set")
 	}
 
-	// Register the handler with a closure to pass the dependencies.
+	// Register the handlers with a closure to pass the dependencies.
+	factory := newGCSWriterFactory(gcsClient.Bucket(bucketName))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		handleRequest(w, r, gcsClient, bucketName)
+		handleRequest(w, r, factory, bucketName)
+	})
+	http.HandleFunc("/objects", func(w http.ResponseWriter, r *http.Request) {
+		handleListObjects(w, r, gcsClient.Bucket(bucketName))
 	})
 
 	// Start the web server.
@@ -75,9 +134,10 @@ func main() {
 	}
 }
 
-// handleRequest processes the incoming HTTP POST request.
-// It now receives the GCS client and bucket name as parameters.
-func handleRequest(w http.ResponseWriter, r *http.Request, gcsClient *storage.Client, bucketName string) {
+// handleRequest processes the incoming HTTP POST request. It writes through
+// an ObjectWriterFactory rather than a concrete *storage.Client so it can be
+// exercised against a fake in tests.
+func handleRequest(w http.ResponseWriter, r *http.Request, factory ObjectWriterFactory, bucketName string) {
 	// Ensure the request method is POST.
 	if r.Method != http.MethodPost {
 		http.Error(w, "Only POST requests are accepted", http.StatusMethodNotAllowed)
@@ -92,74 +152,95 @@ func handleRequest(w http.ResponseWriter, r *http.Request, gcsClient *storage.Cl
 		return
 	}
 
-	// Validate the number.
-	if payload.Number <= 0 {
-		http.Error(w, "The 'number' value must be a positive integer", http.StatusBadRequest)
+	// Validate the object count.
+	if payload.ObjectCount <= 0 {
+		http.Error(w, "The 'object_count' value must be a positive integer", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Received request to create %d objects in bucket '%s'", payload.Number, bucketName)
+	objectSizeBytes := payload.ObjectSizeBytes
+	if objectSizeBytes <= 0 {
+		objectSizeBytes = defaultObjectSizeBytes
+	}
+
+	concurrency := resolveConcurrency(payload.Concurrency)
+	chunkSizeBytes := resolveChunkSize(payload.ChunkSizeBytes, objectSizeBytes)
 
-	// Get a reference to the GCS bucket.
-	bucket := gcsClient.Bucket(bucketName)
+	log.Printf("Received request to create %d objects of %d bytes (chunk size %d) in bucket '%s' with concurrency %d", payload.ObjectCount, objectSizeBytes, chunkSizeBytes, bucketName, concurrency)
 
 	// Use a context with a timeout for GCS operations.
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*60)
 	defer cancel()
 
 	// Start timing the GCS write operations.
 	startTime := time.Now()
 
-	var wg sync.WaitGroup
-	errs := make(chan error, payload.Number)
-
-	for i := 0; i < payload.Number; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+	var (
+		objectsWritten    int64
+		totalBytesWritten int64
+		errMu             sync.Mutex
+		errorMessages     []string
+		errorsByType      = make(map[string]int)
+	)
+
+	sem := semaphore.NewWeighted(int64(concurrency))
+	g, gctx := errgroup.WithContext(ctx)
+
+	for i := 0; i < payload.ObjectCount; i++ {
+		if err := sem.Acquire(gctx, 1); err != nil {
+			// Context was canceled or timed out before this write could start.
+			errMu.Lock()
+			errorMessages = append(errorMessages, fmt.Sprintf("write skipped: %v", err))
+			errMu.Unlock()
+			break
+		}
+
+		g.Go(func() error {
+			defer sem.Release(1)
 
 			// Generate a unique object key (timestamp as folder, random hash as name).
 			objectKey := generateObjectKey()
 
-			// Generate a random string of 1024 characters for the payload.
-			randomString := generateRandomString(1024)
-
-			// Create a new object writer.
-			obj := bucket.Object(objectKey).NewWriter(ctx)
-
-			// Write the random string to the object.
-			if _, err := io.WriteString(obj, randomString); err != nil {
-				errs <- fmt.Errorf("failed to write to GCS object %s: %v", objectKey, err)
-				obj.Close() // Best effort close
-				return
+			n, category, err := writeObjectWithRetry(gctx, factory, objectKey, objectSizeBytes, chunkSizeBytes)
+			if err != nil {
+				errMu.Lock()
+				errorMessages = append(errorMessages, fmt.Sprintf("failed to write GCS object %s: %v", objectKey, err))
+				errorsByType[category]++
+				errMu.Unlock()
+				return nil
 			}
 
-			// Close the writer to finalize the upload.
-			if err := obj.Close(); err != nil {
-				errs <- fmt.Errorf("failed to close GCS object writer for %s: %v", objectKey, err)
-				return
-			}
+			atomic.AddInt64(&objectsWritten, 1)
+			atomic.AddInt64(&totalBytesWritten, n)
 			log.Printf("Successfully created object: %s", objectKey)
-		}()
+			return nil
+		})
 	}
 
-	wg.Wait()
-	close(errs)
+	// g.Wait only returns an error if a goroutine returns one; writes report
+	// their own failures via errorMessages so in-flight work can still drain.
+	_ = g.Wait()
 
 	// Calculate the time taken for the loop.
 	timeTaken := time.Since(startTime)
 
-	// Collect any errors.
-	var errorMessages []string
-	for err := range errs {
-		errorMessages = append(errorMessages, err.Error())
+	seconds := timeTaken.Seconds()
+	var throughput float64
+	if seconds > 0 {
+		throughput = (float64(totalBytesWritten) / (1024 * 1024)) / seconds
 	}
 
 	// Create the JSON response payload.
 	response := ResponsePayload{
-		ObjectsWritten: payload.Number - len(errorMessages),
-		TimeTaken:      timeTaken.String(),
-		Errors:         errorMessages,
+		ObjectsWritten:    int(objectsWritten),
+		TotalBytesWritten: totalBytesWritten,
+		Throughput:        throughput,
+		TimeTaken:         timeTaken.String(),
+		Errors:            errorMessages,
+		ErrorsByType:      errorsByType,
+	}
+	if len(response.ErrorsByType) == 0 {
+		response.ErrorsByType = nil
 	}
 
 	// Set the Content-Type header and encode the response to JSON.
@@ -174,6 +255,134 @@ func handleRequest(w http.ResponseWriter, r *http.Request, gcsClient *storage.Cl
 	}
 }
 
+// resolveConcurrency picks the worker pool size for a request: an explicit
+// per-request value wins, falling back to MAX_CONCURRENT_WRITES and then a
+// built-in default.
+func resolveConcurrency(requested int) int {
+	if requested > 0 {
+		return requested
+	}
+	if v := os.Getenv("MAX_CONCURRENT_WRITES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultConcurrency
+}
+
+// resolveChunkSize picks the GCS resumable-upload chunk size for a write: an
+// explicit per-request value wins; otherwise large objects default to
+// chunked uploads and small ones default to a single-shot upload (ChunkSize
+// 0 disables resumable uploads and their buffering overhead).
+func resolveChunkSize(requested, objectSizeBytes int) int {
+	if requested > 0 {
+		return requested
+	}
+	if objectSizeBytes > chunkSizeThresholdBytes {
+		return defaultChunkSizeBytes
+	}
+	return 0
+}
+
+// ObjectWriterFactory abstracts object creation so handleRequest can be
+// tested against a fake without a real GCS bucket.
+type ObjectWriterFactory interface {
+	NewWriter(ctx context.Context, key string) io.WriteCloser
+}
+
+// gcsWriterFactory implements ObjectWriterFactory against a real GCS bucket,
+// refusing to overwrite an existing object under the same key.
+type gcsWriterFactory struct {
+	bucket *storage.BucketHandle
+}
+
+// newGCSWriterFactory returns an ObjectWriterFactory backed by bucket.
+func newGCSWriterFactory(bucket *storage.BucketHandle) *gcsWriterFactory {
+	return &gcsWriterFactory{bucket: bucket}
+}
+
+func (f *gcsWriterFactory) NewWriter(ctx context.Context, key string) io.WriteCloser {
+	return f.bucket.Object(key).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+}
+
+// writeObjectWithRetry writes a randomly generated object of objectSizeBytes
+// via factory, retrying rate-limit and server errors with capped
+// exponential backoff. On failure it returns the classified error category
+// alongside the underlying error so callers can aggregate errors by type.
+func writeObjectWithRetry(ctx context.Context, factory ObjectWriterFactory, objectKey string, objectSizeBytes, chunkSizeBytes int) (int64, string, error) {
+	backoff := initialRetryBackoff
+
+	var lastErr error
+	var lastCategory string
+
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		obj := factory.NewWriter(ctx, objectKey)
+		if sw, ok := obj.(*storage.Writer); ok {
+			sw.ChunkSize = chunkSizeBytes
+		}
+
+		n, err := io.Copy(obj, newRandomReader(int64(objectSizeBytes)))
+		if err == nil {
+			err = obj.Close()
+		} else {
+			obj.Close() // Best effort close after a failed write.
+		}
+		if err == nil {
+			return n, "", nil
+		}
+
+		category := categorizeError(err)
+		if category != errCategoryServerError {
+			// Fail fast: not found, permission, and precondition errors won't
+			// resolve themselves on retry.
+			return 0, category, err
+		}
+
+		lastErr, lastCategory = err, category
+		if attempt == maxWriteAttempts-1 || !sleepWithBackoff(ctx, &backoff) {
+			break
+		}
+	}
+
+	return 0, lastCategory, lastErr
+}
+
+// categorizeError maps a GCS write error to one of the ResponsePayload error
+// categories using the underlying googleapi.Error status code where present.
+func categorizeError(err error) string {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		switch {
+		case gerr.Code == http.StatusNotFound:
+			return errCategoryBucketNotFound
+		case gerr.Code == http.StatusUnauthorized || gerr.Code == http.StatusForbidden:
+			return errCategoryPermissionDenied
+		case gerr.Code == http.StatusPreconditionFailed:
+			return errCategoryPreconditionFailed
+		case gerr.Code == http.StatusTooManyRequests || gerr.Code >= 500:
+			return errCategoryServerError
+		}
+	}
+	return errCategoryUnknown
+}
+
+// sleepWithBackoff waits for the current backoff duration (plus jitter) or
+// until ctx is done, then doubles backoff for the next attempt. It returns
+// false if ctx was canceled before the wait completed.
+func sleepWithBackoff(ctx context.Context, backoff *time.Duration) bool {
+	jitter := time.Duration(mathrand.Int63n(int64(*backoff)))
+	wait := *backoff + jitter
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(wait):
+	}
+
+	*backoff *= retryBackoffFactor
+	return true
+}
+
 // generateObjectKey creates a unique key using the timestamp as a folder and a random hash as the filename.
 func generateObjectKey() string {
 	// Get the current time and format it for the folder name (e.g., "20250812T232000").
@@ -188,13 +397,169 @@ func generateObjectKey() string {
 	return fmt.Sprintf("%s/%s", timestamp, randomHash)
 }
 
-// generateRandomString creates a random string of the specified length.
-func generateRandomString(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	rand.Read(b)
-	for i := 0; i < length; i++ {
-		b[i] = charset[b[i]%byte(len(charset))]
+// randomStringCharset is the alphabet used for generated object payloads.
+const randomStringCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// randomReader is an io.Reader that emits n bytes of random charset data,
+// generating each chunk on demand instead of allocating the full payload
+// up front. This keeps memory flat for large object_size_bytes requests.
+type randomReader struct {
+	remaining int64
+}
+
+// newRandomReader returns a randomReader that yields exactly n bytes before
+// returning io.EOF.
+func newRandomReader(n int64) *randomReader {
+	return &randomReader{remaining: n}
+}
+
+func (r *randomReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := rand.Read(p)
+	if err != nil {
+		return n, err
+	}
+	for i := 0; i < n; i++ {
+		p[i] = randomStringCharset[p[i]%byte(len(randomStringCharset))]
+	}
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+// handleListObjects handles GET /objects?prefix=... . It lists objects in
+// bucket under prefix and, depending on ?format=, returns either a plain
+// name listing ("list", the default), a newline-delimited JSON manifest
+// with each object's contents inlined ("ndjson"), or a tar archive of the
+// matched objects' contents ("tar"). bucket is passed in by the caller
+// (rather than read off a package global) so it can be pointed at a fake
+// server in tests, the same way handleRequest takes an ObjectWriterFactory.
+func handleListObjects(w http.ResponseWriter, r *http.Request, bucket *storage.BucketHandle) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+
+	prefix, suffix, err := splitWildcardPrefix(query.Get("prefix"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultListLimit
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, "The 'limit' query param must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
 	}
-	return string(b)
-}
\ No newline at end of file
+
+	it := bucket.Objects(r.Context(), &storage.Query{
+		Prefix:      prefix,
+		StartOffset: query.Get("start_offset"),
+		EndOffset:   query.Get("end_offset"),
+	})
+
+	var names []string
+	for len(names) < limit {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to list objects: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if suffix != "" && !strings.HasSuffix(attrs.Name, suffix) {
+			continue
+		}
+		names = append(names, attrs.Name)
+	}
+
+	switch query.Get("format") {
+	case "tar":
+		writeObjectsAsTar(r.Context(), w, bucket, names)
+	case "ndjson":
+		writeObjectsAsNDJSON(r.Context(), w, bucket, names)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Objects []string `json:"objects"`
+		}{Objects: names})
+	}
+}
+
+// splitWildcardPrefix splits pattern on a single '*' into the GCS
+// storage.Query prefix (everything before it) and a suffix that matched
+// object names must end with (everything after it). Patterns with more
+// than one '*' are rejected.
+func splitWildcardPrefix(pattern string) (prefix, suffix string, err error) {
+	if strings.Count(pattern, "*") > 1 {
+		return "", "", fmt.Errorf("the 'prefix' query param supports at most one '*' wildcard")
+	}
+	idx := strings.Index(pattern, "*")
+	if idx == -1 {
+		return pattern, "", nil
+	}
+	return pattern[:idx], pattern[idx+1:], nil
+}
+
+// writeObjectsAsNDJSON streams each named object as one JSON line containing
+// its name and base64-encoded contents.
+func writeObjectsAsNDJSON(ctx context.Context, w http.ResponseWriter, bucket *storage.BucketHandle, names []string) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, name := range names {
+		data, err := readObject(ctx, bucket, name)
+		if err != nil {
+			log.Printf("failed to read object %s for ndjson manifest: %v", name, err)
+			continue
+		}
+		enc.Encode(struct {
+			Name string `json:"name"`
+			Data string `json:"data"`
+		}{Name: name, Data: base64.StdEncoding.EncodeToString(data)})
+	}
+}
+
+// writeObjectsAsTar streams each named object's contents into a tar archive.
+func writeObjectsAsTar(ctx context.Context, w http.ResponseWriter, bucket *storage.BucketHandle, names []string) {
+	w.Header().Set("Content-Type", "application/x-tar")
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	for _, name := range names {
+		data, err := readObject(ctx, bucket, name)
+		if err != nil {
+			log.Printf("failed to read object %s for tar manifest: %v", name, err)
+			continue
+		}
+		header := &tar.Header{Name: name, Size: int64(len(data)), Mode: 0644}
+		if err := tw.WriteHeader(header); err != nil {
+			log.Printf("failed to write tar header for %s: %v", name, err)
+			return
+		}
+		if _, err := tw.Write(data); err != nil {
+			log.Printf("failed to write tar contents for %s: %v", name, err)
+			return
+		}
+	}
+}
+
+// readObject reads the full contents of bucket/name.
+func readObject(ctx context.Context, bucket *storage.BucketHandle, name string) ([]byte, error) {
+	reader, err := bucket.Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
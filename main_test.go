@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theemadnes/golang-gcs-writer-test-01/testing/fakegcs"
+)
+
+func withFastRetries(t *testing.T) {
+	t.Helper()
+	origAttempts, origBackoff := maxWriteAttempts, initialRetryBackoff
+	maxWriteAttempts = 3
+	initialRetryBackoff = time.Millisecond
+	t.Cleanup(func() {
+		maxWriteAttempts, initialRetryBackoff = origAttempts, origBackoff
+	})
+}
+
+func newTestServer(t *testing.T, failEvery int) *fakegcs.Server {
+	t.Helper()
+	server := fakegcs.NewServer(failEvery)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func doRequest(t *testing.T, factory ObjectWriterFactory, payload Payload) (*httptest.ResponseRecorder, ResponsePayload) {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+	rec := httptest.NewRecorder()
+
+	handleRequest(rec, req, factory, "test-bucket")
+
+	var resp ResponsePayload
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return rec, resp
+}
+
+func TestHandleRequest_SuccessfulWrites(t *testing.T) {
+	withFastRetries(t)
+	fake := newTestServer(t, 0)
+	client, err := fake.Client(context.Background())
+	if err != nil {
+		t.Fatalf("fake client: %v", err)
+	}
+	factory := newGCSWriterFactory(client.Bucket("test-bucket"))
+
+	rec, resp := doRequest(t, factory, Payload{ObjectCount: 5, ObjectSizeBytes: 128, Concurrency: 2})
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; errors: %v", rec.Code, resp.Errors)
+	}
+	if resp.ObjectsWritten != 5 {
+		t.Errorf("ObjectsWritten = %d, want 5", resp.ObjectsWritten)
+	}
+	if resp.TotalBytesWritten != 5*128 {
+		t.Errorf("TotalBytesWritten = %d, want %d", resp.TotalBytesWritten, 5*128)
+	}
+	if len(fake.Objects()) != 5 {
+		t.Errorf("fake server has %d objects, want 5", len(fake.Objects()))
+	}
+}
+
+func TestHandleRequest_PartialFailure(t *testing.T) {
+	withFastRetries(t)
+	fake := newTestServer(t, 3) // every third distinct object always fails
+	client, err := fake.Client(context.Background())
+	if err != nil {
+		t.Fatalf("fake client: %v", err)
+	}
+	factory := newGCSWriterFactory(client.Bucket("test-bucket"))
+
+	_, resp := doRequest(t, factory, Payload{ObjectCount: 6, ObjectSizeBytes: 64, Concurrency: 1})
+
+	if resp.ObjectsWritten != 4 {
+		t.Errorf("ObjectsWritten = %d, want 4", resp.ObjectsWritten)
+	}
+	if len(resp.Errors) != 2 {
+		t.Errorf("len(Errors) = %d, want 2", len(resp.Errors))
+	}
+	if got := resp.ErrorsByType[errCategoryServerError]; got != 2 {
+		t.Errorf("ErrorsByType[%q] = %d, want 2", errCategoryServerError, got)
+	}
+}
+
+func TestHandleRequest_ResumableUpload(t *testing.T) {
+	withFastRetries(t)
+	fake := newTestServer(t, 0)
+	client, err := fake.Client(context.Background())
+	if err != nil {
+		t.Fatalf("fake client: %v", err)
+	}
+	factory := newGCSWriterFactory(client.Bucket("test-bucket"))
+
+	// Set an explicit chunk size so the writer takes the ChunkSize > 0 path.
+	// The object is smaller than one chunk, so the storage client still sends
+	// it in a single request, served by the fake's handleMultipart.
+	rec, resp := doRequest(t, factory, Payload{ObjectCount: 2, ObjectSizeBytes: 128, ChunkSizeBytes: 256 * 1024})
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; errors: %v", rec.Code, resp.Errors)
+	}
+	if resp.ObjectsWritten != 2 {
+		t.Errorf("ObjectsWritten = %d, want 2", resp.ObjectsWritten)
+	}
+	if len(fake.Objects()) != 2 {
+		t.Errorf("fake server has %d objects, want 2", len(fake.Objects()))
+	}
+}
+
+func TestHandleRequest_TimeoutCancellation(t *testing.T) {
+	withFastRetries(t)
+	fake := newTestServer(t, 0)
+	client, err := fake.Client(context.Background())
+	if err != nil {
+		t.Fatalf("fake client: %v", err)
+	}
+	factory := newGCSWriterFactory(client.Bucket("test-bucket"))
+
+	body, err := json.Marshal(Payload{ObjectCount: 1000, ObjectSizeBytes: 64, Concurrency: 1})
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body))).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	handleRequest(rec, req, factory, "test-bucket")
+
+	var resp ResponsePayload
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.ObjectsWritten >= 1000 {
+		t.Errorf("ObjectsWritten = %d, want a partial count below 1000", resp.ObjectsWritten)
+	}
+}
+
+func TestHandleRequest_PreconditionViolation(t *testing.T) {
+	withFastRetries(t)
+	fake := newTestServer(t, 0)
+	client, err := fake.Client(context.Background())
+	if err != nil {
+		t.Fatalf("fake client: %v", err)
+	}
+	bucket := client.Bucket("test-bucket")
+	factory := newGCSWriterFactory(bucket)
+
+	// Write once directly so the object already exists under a fixed key.
+	if _, _, err := writeObjectWithRetry(context.Background(), factory, "fixed-key", 32, 0); err != nil {
+		t.Fatalf("seed write failed: %v", err)
+	}
+
+	_, category, err := writeObjectWithRetry(context.Background(), factory, "fixed-key", 32, 0)
+	if err == nil {
+		t.Fatal("expected an error writing over an existing object")
+	}
+	if category != errCategoryPreconditionFailed {
+		t.Errorf("category = %q, want %q", category, errCategoryPreconditionFailed)
+	}
+}
+
+func TestSplitWildcardPrefix(t *testing.T) {
+	tests := []struct {
+		name       string
+		pattern    string
+		wantPrefix string
+		wantSuffix string
+		wantErr    bool
+	}{
+		{name: "no wildcard", pattern: "20250812T", wantPrefix: "20250812T"},
+		{name: "trailing wildcard", pattern: "20250812T*", wantPrefix: "20250812T"},
+		{name: "wildcard with suffix", pattern: "20250812T*/", wantPrefix: "20250812T", wantSuffix: "/"},
+		{name: "bare wildcard", pattern: "*", wantPrefix: "", wantSuffix: ""},
+		{name: "too many wildcards", pattern: "a*b*c", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, suffix, err := splitWildcardPrefix(tt.pattern)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if prefix != tt.wantPrefix || suffix != tt.wantSuffix {
+				t.Errorf("splitWildcardPrefix(%q) = (%q, %q), want (%q, %q)", tt.pattern, prefix, suffix, tt.wantPrefix, tt.wantSuffix)
+			}
+		})
+	}
+}
+
+func TestHandleListObjects(t *testing.T) {
+	withFastRetries(t)
+	fake := newTestServer(t, 0)
+	client, err := fake.Client(context.Background())
+	if err != nil {
+		t.Fatalf("fake client: %v", err)
+	}
+
+	bucket := client.Bucket("test-bucket")
+	factory := newGCSWriterFactory(bucket)
+	if _, resp := doRequest(t, factory, Payload{ObjectCount: 3, ObjectSizeBytes: 16}); resp.ObjectsWritten != 3 {
+		t.Fatalf("seed writes: ObjectsWritten = %d, want 3", resp.ObjectsWritten)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/objects?prefix=", nil)
+	rec := httptest.NewRecorder()
+	handleListObjects(rec, req, bucket)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	}
+	var listResp struct {
+		Objects []string `json:"objects"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&listResp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(listResp.Objects) != 3 {
+		t.Errorf("len(Objects) = %d, want 3", len(listResp.Objects))
+	}
+}
@@ -0,0 +1,355 @@
+// Package fakegcs provides a minimal in-process fake of the GCS JSON API,
+// sufficient for exercising handleRequest's write path and the /objects
+// listing endpoint without a real bucket. It understands multipart and
+// resumable uploads, object listing and download, and the
+// ifGenerationMatch=0 precondition that the storage client sends for
+// storage.Conditions{DoesNotExist: true}.
+package fakegcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// Server is a fake GCS server that stores uploaded objects in memory.
+type Server struct {
+	httpServer *httptest.Server
+	failEvery  int
+
+	mu           sync.Mutex
+	objects      map[string][]byte
+	sessions     map[string]*resumableSession
+	objectOrder  map[string]int
+	orderCounter int
+}
+
+type resumableSession struct {
+	bucket             string
+	name               string
+	ifGenerationMatch0 bool
+	body               bytes.Buffer
+}
+
+// NewServer starts a fake GCS server. If failEvery > 0, every failEvery'th
+// distinct object name (in first-seen order) always fails with a 500,
+// regardless of how many times the client retries it, so retry-exhaustion
+// paths can be exercised deterministically.
+func NewServer(failEvery int) *Server {
+	s := &Server{
+		failEvery:   failEvery,
+		objects:     make(map[string][]byte),
+		sessions:    make(map[string]*resumableSession),
+		objectOrder: make(map[string]int),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload/storage/v1/b/", s.handleUpload)
+	mux.HandleFunc("/storage/v1/b/", s.handleObjectsAPI)
+	s.httpServer = httptest.NewServer(mux)
+	return s
+}
+
+// Close shuts down the fake server.
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// Client returns a *storage.Client wired to the fake server instead of the
+// real GCS JSON API.
+func (s *Server) Client(ctx context.Context) (*storage.Client, error) {
+	return storage.NewClient(ctx,
+		option.WithEndpoint(s.httpServer.URL+"/storage/v1/"),
+		option.WithHTTPClient(s.httpServer.Client()),
+		option.WithoutAuthentication(),
+	)
+}
+
+// Objects returns a snapshot of every object successfully committed so far,
+// keyed by object name.
+func (s *Server) Objects() map[string][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string][]byte, len(s.objects))
+	for k, v := range s.objects {
+		out[k] = v
+	}
+	return out
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	bucket := bucketFromPath(r.URL.Path)
+	query := r.URL.Query()
+
+	switch query.Get("uploadType") {
+	case "resumable":
+		if uploadID := query.Get("upload_id"); uploadID != "" {
+			s.handleResumableChunk(w, r, uploadID)
+			return
+		}
+		s.handleResumableInit(w, r, bucket, query.Get("name"))
+	default:
+		s.handleMultipart(w, r, bucket)
+	}
+}
+
+func (s *Server) handleResumableInit(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	ifGenerationMatch0 := r.URL.Query().Get("ifGenerationMatch") == "0"
+
+	s.mu.Lock()
+	sessionID := strconv.Itoa(len(s.sessions) + 1)
+	s.sessions[sessionID] = &resumableSession{bucket: bucket, name: name, ifGenerationMatch0: ifGenerationMatch0}
+	s.mu.Unlock()
+
+	location := fmt.Sprintf("%s%s?uploadType=resumable&upload_id=%s", s.httpServer.URL, r.URL.Path, sessionID)
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleResumableChunk(w http.ResponseWriter, r *http.Request, uploadID string) {
+	s.mu.Lock()
+	session, ok := s.sessions[uploadID]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown upload session", http.StatusNotFound)
+		return
+	}
+
+	total, final := parseContentRangeTotal(r.Header.Get("Content-Range"))
+	if _, err := session.body.ReadFrom(r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !final {
+		w.WriteHeader(http.StatusPermanentRedirect) // 308 Resume Incomplete
+		return
+	}
+
+	if total >= 0 && int64(session.body.Len()) != total {
+		http.Error(w, "short upload", http.StatusBadRequest)
+		return
+	}
+
+	s.commit(w, session.bucket, session.name, session.body.Bytes(), session.ifGenerationMatch0)
+}
+
+func (s *Server) handleMultipart(w http.ResponseWriter, r *http.Request, bucket string) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		http.Error(w, "expected multipart/related upload", http.StatusBadRequest)
+		return
+	}
+
+	reader := multipart.NewReader(r.Body, params["boundary"])
+
+	metaPart, err := reader.NextPart()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var meta struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(metaPart).Decode(&meta); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mediaPart, err := reader.NextPart()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(mediaPart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.commit(w, bucket, meta.Name, body.Bytes(), r.URL.Query().Get("ifGenerationMatch") == "0")
+}
+
+// commit applies the ifGenerationMatch=0 precondition and failure injection,
+// then stores the object and writes the JSON response the client expects.
+// ifGenerationMatch0 must reflect the precondition as negotiated at upload
+// start (the session-init POST for resumable uploads), since that is the
+// only request on which the real client ever sends it.
+func (s *Server) commit(w http.ResponseWriter, bucket, name string, data []byte, ifGenerationMatch0 bool) {
+	if ifGenerationMatch0 {
+		s.mu.Lock()
+		_, exists := s.objects[name]
+		s.mu.Unlock()
+		if exists {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+	}
+
+	if s.shouldFail(name) {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	s.objects[name] = data
+	s.mu.Unlock()
+
+	crc := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+	var crcBytes [4]byte
+	binary.BigEndian.PutUint32(crcBytes[:], crc)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"name":   name,
+		"bucket": bucket,
+		"crc32c": base64.StdEncoding.EncodeToString(crcBytes[:]),
+	})
+}
+
+// shouldFail reports whether name belongs to the fixed subset of objects
+// this server always fails, assigning each newly seen name a stable
+// first-seen order so retries of the same object are consistently rejected.
+func (s *Server) shouldFail(name string) bool {
+	if s.failEvery <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx, ok := s.objectOrder[name]
+	if !ok {
+		s.orderCounter++
+		idx = s.orderCounter
+		s.objectOrder[name] = idx
+	}
+	return idx%s.failEvery == 0
+}
+
+// handleObjectsAPI serves the "/storage/v1/b/{bucket}/o[/{object}]" routes
+// used to list and download objects.
+func (s *Server) handleObjectsAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/")
+	bucket, rest, ok := strings.Cut(path, "/o")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	rest = strings.TrimPrefix(rest, "/")
+	if rest == "" {
+		s.handleListObjects(w, r, bucket)
+		return
+	}
+
+	name, err := url.PathUnescape(rest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.handleGetObject(w, r, name)
+}
+
+func (s *Server) handleListObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	startOffset := query.Get("startOffset")
+	endOffset := query.Get("endOffset")
+
+	s.mu.Lock()
+	names := make([]string, 0, len(s.objects))
+	for name := range s.objects {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if startOffset != "" && name < startOffset {
+			continue
+		}
+		if endOffset != "" && name >= endOffset {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type item struct {
+		Name   string `json:"name"`
+		Bucket string `json:"bucket"`
+		Size   string `json:"size"`
+	}
+	items := make([]item, 0, len(names))
+	for _, name := range names {
+		items = append(items, item{Name: name, Bucket: bucket, Size: strconv.Itoa(len(s.objects[name]))})
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Items []item `json:"items"`
+	}{Items: items})
+}
+
+func (s *Server) handleGetObject(w http.ResponseWriter, r *http.Request, name string) {
+	s.mu.Lock()
+	data, ok := s.objects[name]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "object not found", http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("alt") != "media" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"name": name,
+			"size": strconv.Itoa(len(data)),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// bucketFromPath extracts the bucket name from
+// "/upload/storage/v1/b/{bucket}/o".
+func bucketFromPath(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/upload/storage/v1/b/"), "/")
+	if len(parts) == 0 {
+		return ""
+	}
+	return parts[0]
+}
+
+// parseContentRangeTotal parses a "bytes start-end/total" Content-Range
+// header, returning the total size and whether it is known (i.e. this is
+// the final chunk). An unknown total ("*") reports final=false.
+func parseContentRangeTotal(header string) (total int64, final bool) {
+	idx := strings.LastIndex(header, "/")
+	if idx == -1 || idx == len(header)-1 {
+		return -1, false
+	}
+	totalStr := header[idx+1:]
+	if totalStr == "*" {
+		return -1, false
+	}
+	n, err := strconv.ParseInt(totalStr, 10, 64)
+	if err != nil {
+		return -1, false
+	}
+	return n, true
+}